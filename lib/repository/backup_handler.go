@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// BackupHandler returns an http.Handler that streams a hot Backup snapshot
+// of the database on demand. authorize is consulted before anything is
+// read from the database or written to the response; it must report
+// whether req is allowed to download a full snapshot (e.g. checking an
+// operator token or session), since this endpoint hands out the entire
+// database. The web layer is expected to mount the result at /admin/backup,
+// the same way it mounts other admin-only routes.
+//
+// The snapshot is first written to a temporary file, and only copied to w
+// once Backup has succeeded in full, so a failure partway through never
+// corrupts a response that's already started. This trades disk space in
+// the default temp directory (one full DB copy per in-flight request) for
+// that safety; deployments backing up large databases under a small or
+// read-only temp dir should give it enough room, e.g. via TMPDIR.
+func (r *boltCoreRepository) BackupHandler(authorize func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if authorize == nil || !authorize(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		tmp, err := os.CreateTemp("", "prchecklist-backup-*.bolt")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if err := r.Backup(req.Context(), tmp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="backup.bolt"`)
+		if _, err := io.Copy(w, tmp); err != nil {
+			log.Printf("bolt: streaming backup to client failed: %v", err)
+		}
+	})
+}
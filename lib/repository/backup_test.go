@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupHandler_RequiresAuthorize(t *testing.T) {
+	r := newTestBoltCore(t)
+
+	handler := r.BackupHandler(func(*http.Request) bool { return false })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatalf("expected an error body, got none")
+	}
+}
+
+func TestBackupHandler_StreamsSnapshotWhenAuthorized(t *testing.T) {
+	r := newTestBoltCore(t)
+
+	handler := r.BackupHandler(func(*http.Request) bool { return true })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatalf("expected a non-empty snapshot body")
+	}
+}
+
+func TestPruneBackups_KeepsOnlyMostRecent(t *testing.T) {
+	dir := t.TempDir()
+
+	names := []string{
+		"20260101T000000.000000000Z.bolt.gz",
+		"20260102T000000.000000000Z.bolt.gz",
+		"20260103T000000.000000000Z.bolt.gz",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+
+	if err := pruneBackups(dir, 2); err != nil {
+		t.Fatalf("pruneBackups: %v", err)
+	}
+
+	remaining, err := filepath.Glob(filepath.Join(dir, "*.bolt.gz"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("remaining = %v, want 2 entries", remaining)
+	}
+	for _, name := range remaining {
+		if filepath.Base(name) == names[0] {
+			t.Errorf("oldest snapshot %s should have been pruned", names[0])
+		}
+	}
+}
@@ -2,9 +2,10 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -18,176 +19,189 @@ func init() {
 }
 
 type boltCoreRepository struct {
-	db *bolt.DB
+	db    *bolt.DB
+	codec Codec
 }
 
 const (
 	boltBucketNameUsers  = "users"
 	boltBucketNameChecks = "checks"
+	boltBucketNameMeta   = "_meta"
+
+	boltMetaKeyCodec        = "codec"
+	boltMetaKeyIndexesBuilt = "indexes_built"
 )
 
 // NewBoltCore creates a coreRepository backed by boltdb.
 // The datasource must start with "bolt:", followed by a path on the filesystem,
-// which passed to bolt.Open.
+// which passed to bolt.Open. Query parameters on the datasource configure it
+// further:
+//
+//   - codec: the encoding used to store values, "json" (default, for backward
+//     compatibility) or "msgpack".
+//   - backup_dir, backup_interval, keep: see startBackupLoop.
 func NewBoltCore(datasource string) (coreRepository, error) {
-	path := datasource[len("bolt:"):]
+	config, err := parseBoltDatasource(datasource)
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	codec, err := codecByName(config.codecName)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte(boltBucketNameUsers)); err != nil {
-			return err
-		}
-		if _, err := tx.CreateBucketIfNotExists([]byte(boltBucketNameChecks)); err != nil {
-			return err
-		}
-		return nil
-	})
+	db, err := bolt.Open(config.path, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, err
 	}
 
-	return &boltCoreRepository{db: db}, nil
-}
+	r := &boltCoreRepository{db: db, codec: codec}
 
-// AddUser implements coreRepository.AddUser.
-func (r boltCoreRepository) AddUser(ctx context.Context, user prchecklist.GitHubUser) error {
-	return r.db.Update(func(tx *bolt.Tx) error {
-		usersBucket := tx.Bucket([]byte(boltBucketNameUsers))
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucketName := range []string{
+			boltBucketNameUsers,
+			boltBucketNameChecks,
+			boltBucketNameChecksByUser,
+			boltBucketNameChecksByRepo,
+			boltBucketNameChecksHistory,
+			boltBucketNameChecksIndexRef,
+		} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
+				return err
+			}
+		}
 
-		buf, err := json.Marshal(user)
+		metaBucket, err := tx.CreateBucketIfNotExists([]byte(boltBucketNameMeta))
 		if err != nil {
 			return err
 		}
 
-		return usersBucket.Put([]byte(strconv.FormatInt(int64(user.ID), 10)), buf)
-	})
-}
-
-// GetUsers implements coreRepository.GetUser.
-func (r boltCoreRepository) GetUsers(ctx context.Context, userIDs []int) (map[int]prchecklist.GitHubUser, error) {
-	users := make(map[int]prchecklist.GitHubUser, len(userIDs))
-	err := r.db.View(func(tx *bolt.Tx) error {
-		usersBucket := tx.Bucket([]byte(boltBucketNameUsers))
-
-		for _, id := range userIDs {
-			buf := usersBucket.Get([]byte(strconv.FormatInt(int64(id), 10)))
-			if buf == nil {
-				return fmt.Errorf("not found: user id=%v", id)
-			}
-
-			var user prchecklist.GitHubUser
-			if err := json.Unmarshal(buf, &user); err != nil {
+		storedCodecName := metaBucket.Get([]byte(boltMetaKeyCodec))
+		if storedCodecName == nil {
+			if err := metaBucket.Put([]byte(boltMetaKeyCodec), []byte(codec.Name())); err != nil {
 				return err
 			}
-			users[id] = user
+		} else if string(storedCodecName) != codec.Name() {
+			return fmt.Errorf("bolt: database was created with codec %q, cannot open with codec %q (use repository.MigrateCodec to convert it)", storedCodecName, codec.Name())
 		}
 
-		return nil
+		return r.migrateCheckIndexes(tx)
 	})
-
-	return users, errors.Wrap(err, "GetUsers")
-}
-
-// GetChecks implements coreRepository.GetChecks.
-func (r boltCoreRepository) GetChecks(ctx context.Context, clRef prchecklist.ChecklistRef) (prchecklist.Checks, error) {
-	if err := clRef.Validate(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	var checks prchecklist.Checks
-
-	err := r.db.View(func(tx *bolt.Tx) error {
-		checksBucket := tx.Bucket([]byte(boltBucketNameChecks))
+	if config.backupDir != "" {
+		r.startBackupLoop(config.backupDir, config.backupInterval, config.backupKeep)
+	}
 
-		key := []byte(clRef.String())
-		data := checksBucket.Get(key)
-		if data != nil {
-			err := json.Unmarshal(data, &checks)
-			if err != nil {
-				return err
-			}
-		}
+	return r, nil
+}
 
-		return nil
-	})
+// boltDatasourceConfig holds the path and query parameters parsed out of a
+// "bolt:" datasource.
+type boltDatasourceConfig struct {
+	path      string
+	codecName string
 
-	return checks, errors.Wrap(err, "GetChecks")
+	backupDir      string
+	backupInterval time.Duration
+	backupKeep     int
 }
 
-// AddCheck implements coreRepository.AddCheck.
-func (r boltCoreRepository) AddCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
-	if err := clRef.Validate(); err != nil {
-		return err
+// parseBoltDatasource splits a "bolt:" datasource into its filesystem path
+// and query parameters, e.g.
+// "bolt:/path/to.db?codec=msgpack&backup_dir=/var/backups&backup_interval=1h&keep=24".
+func parseBoltDatasource(datasource string) (boltDatasourceConfig, error) {
+	rest := datasource[len("bolt:"):]
+
+	config := boltDatasourceConfig{
+		path:           rest,
+		backupInterval: time.Hour,
+		backupKeep:     24,
 	}
 
-	return r.db.Update(func(tx *bolt.Tx) error {
-		var checks prchecklist.Checks
+	i := strings.IndexByte(rest, '?')
+	if i < 0 {
+		return config, nil
+	}
 
-		checksBucket := tx.Bucket([]byte(boltBucketNameChecks))
+	config.path = rest[:i]
 
-		dbKey := []byte(clRef.String())
-		data := checksBucket.Get(dbKey)
-		if data != nil {
-			err := json.Unmarshal(data, &checks)
-			if err != nil {
-				return err
-			}
-		}
+	query, err := url.ParseQuery(rest[i+1:])
+	if err != nil {
+		return boltDatasourceConfig{}, errors.Wrap(err, "parsing bolt datasource")
+	}
 
-		if checks == nil {
-			checks = prchecklist.Checks{}
-		}
+	config.codecName = query.Get("codec")
+	config.backupDir = query.Get("backup_dir")
 
-		if checks.Add(key, user) == false {
-			return nil
+	if v := query.Get("backup_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return boltDatasourceConfig{}, errors.Wrap(err, "parsing backup_interval")
+		}
+		if d <= 0 {
+			return boltDatasourceConfig{}, fmt.Errorf("backup_interval must be positive, got %s", d)
 		}
+		config.backupInterval = d
+	}
 
-		data, err := json.Marshal(&checks)
+	if v := query.Get("keep"); v != "" {
+		n, err := strconv.Atoi(v)
 		if err != nil {
-			return err
+			return boltDatasourceConfig{}, errors.Wrap(err, "parsing keep")
 		}
+		config.backupKeep = n
+	}
+
+	return config, nil
+}
 
-		return checksBucket.Put(dbKey, data)
+// AddUser implements coreRepository.AddUser. It opens a single writable
+// transaction and delegates to TxRepository, which holds the actual bucket
+// logic (see tx.go).
+func (r *boltCoreRepository) AddUser(ctx context.Context, user prchecklist.GitHubUser) error {
+	return r.WithTx(ctx, func(tx TxRepository) error {
+		return tx.AddUser(ctx, user)
 	})
 }
 
-// RemoveCheck implements coreRepository.RemoveCheck.
-func (r boltCoreRepository) RemoveCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
-	if err := clRef.Validate(); err != nil {
+// GetUsers implements coreRepository.GetUsers.
+func (r *boltCoreRepository) GetUsers(ctx context.Context, userIDs []int) (map[int]prchecklist.GitHubUser, error) {
+	var users map[int]prchecklist.GitHubUser
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		users, err = (&boltTxRepository{tx: tx, codec: r.codec}).GetUsers(ctx, userIDs)
 		return err
-	}
-
-	return r.db.Update(func(tx *bolt.Tx) error {
-		var checks prchecklist.Checks
-
-		checksBucket := tx.Bucket([]byte(boltBucketNameChecks))
+	})
 
-		dbKey := []byte(clRef.String())
-		data := checksBucket.Get(dbKey)
-		if data != nil {
-			err := json.Unmarshal(data, &checks)
-			if err != nil {
-				return err
-			}
-		}
+	return users, errors.Wrap(err, "GetUsers")
+}
 
-		if checks == nil {
-			checks = prchecklist.Checks{}
-		}
+// GetChecks implements coreRepository.GetChecks.
+func (r *boltCoreRepository) GetChecks(ctx context.Context, clRef prchecklist.ChecklistRef) (prchecklist.Checks, error) {
+	var checks prchecklist.Checks
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		checks, err = (&boltTxRepository{tx: tx, codec: r.codec}).GetChecks(ctx, clRef)
+		return err
+	})
 
-		if checks.Remove(key, user) == false {
-			return nil
-		}
+	return checks, errors.Wrap(err, "GetChecks")
+}
 
-		data, err := json.Marshal(&checks)
-		if err != nil {
-			return err
-		}
+// AddCheck implements coreRepository.AddCheck.
+func (r *boltCoreRepository) AddCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
+	return r.WithTx(ctx, func(tx TxRepository) error {
+		return tx.AddCheck(ctx, clRef, key, user)
+	})
+}
 
-		return checksBucket.Put(dbKey, data)
+// RemoveCheck implements coreRepository.RemoveCheck.
+func (r *boltCoreRepository) RemoveCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
+	return r.WithTx(ctx, func(tx TxRepository) error {
+		return tx.RemoveCheck(ctx, clRef, key, user)
 	})
 }
@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+const (
+	boltBucketNameChecksByUser   = "checks_by_user"
+	boltBucketNameChecksByRepo   = "checks_by_repo"
+	boltBucketNameChecksHistory  = "checks_history"
+	boltBucketNameChecksIndexRef = "checks_index_ref"
+
+	// boltCheckEventAdd and boltCheckEventRemove record which operation
+	// produced a checks_history entry.
+	boltCheckEventAdd    = "add"
+	boltCheckEventRemove = "remove"
+)
+
+// CheckListEntry is a single row returned by ListChecksByUser and
+// ListChecksByRepo.
+type CheckListEntry struct {
+	CLRef     prchecklist.ChecklistRef
+	Key       string
+	Timestamp time.Time
+}
+
+// CheckHistoryEntry is a single add/remove event recorded in the
+// checks_history bucket.
+type CheckHistoryEntry struct {
+	Action    string
+	Key       string
+	Actor     prchecklist.GitHubUser
+	Timestamp time.Time
+}
+
+// encodeBoltTimestamp renders t as a fixed-width, lexicographically sortable
+// string suitable for use in composite bolt keys.
+func encodeBoltTimestamp(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+func boltChecksByUserKey(userID int, ts time.Time, clRef string) []byte {
+	return []byte(fmt.Sprintf("%d/%s/%s", userID, encodeBoltTimestamp(ts), clRef))
+}
+
+func boltChecksByRepoKey(owner, repo string, ts time.Time, clRef string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s/%s", owner, repo, encodeBoltTimestamp(ts), clRef))
+}
+
+func boltChecksHistoryKey(clRef string, ts time.Time) []byte {
+	return []byte(fmt.Sprintf("%s/%s", clRef, encodeBoltTimestamp(ts)))
+}
+
+// boltChecksIndexRefKey identifies a checked key within a checklist
+// regardless of when it was checked, so a later RemoveCheck can find the
+// checks_by_user/checks_by_repo entries an earlier AddCheck wrote.
+func boltChecksIndexRefKey(clRef string, key string, userID int) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%d", clRef, key, userID))
+}
+
+// putCheckIndexes keeps checks_by_user, checks_by_repo and checks_history in
+// sync with a single add/remove event. It must be called inside the same
+// db.Update transaction as the primary checks record write so the indexes
+// never drift out of sync with it.
+//
+// checks_by_user and checks_by_repo list currently-checked keys, so only an
+// add writes them; a remove deletes the entries the matching add wrote,
+// looking them up via checks_index_ref. checks_history is append-only and
+// records both actions.
+func putCheckIndexes(tx *bolt.Tx, codec Codec, action string, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser, ts time.Time) error {
+	byUserBucket := tx.Bucket([]byte(boltBucketNameChecksByUser))
+	byRepoBucket := tx.Bucket([]byte(boltBucketNameChecksByRepo))
+	historyBucket := tx.Bucket([]byte(boltBucketNameChecksHistory))
+	indexRefBucket := tx.Bucket([]byte(boltBucketNameChecksIndexRef))
+
+	clRefString := clRef.String()
+	indexRefKey := boltChecksIndexRefKey(clRefString, key, user.ID)
+
+	switch action {
+	case boltCheckEventAdd:
+		if err := byUserBucket.Put(boltChecksByUserKey(user.ID, ts, clRefString), []byte(key)); err != nil {
+			return err
+		}
+		if err := byRepoBucket.Put(boltChecksByRepoKey(clRef.Owner, clRef.Repo, ts, clRefString), []byte(key)); err != nil {
+			return err
+		}
+		if err := indexRefBucket.Put(indexRefKey, []byte(encodeBoltTimestamp(ts))); err != nil {
+			return err
+		}
+
+	case boltCheckEventRemove:
+		if addedAt := indexRefBucket.Get(indexRefKey); addedAt != nil {
+			if err := byUserBucket.Delete(boltChecksByUserKey(user.ID, decodeBoltTimestampOrZero(string(addedAt)), clRefString)); err != nil {
+				return err
+			}
+			if err := byRepoBucket.Delete(boltChecksByRepoKey(clRef.Owner, clRef.Repo, decodeBoltTimestampOrZero(string(addedAt)), clRefString)); err != nil {
+				return err
+			}
+			if err := indexRefBucket.Delete(indexRefKey); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("putCheckIndexes: unknown action %q", action)
+	}
+
+	historyData, err := codec.Marshal(&CheckHistoryEntry{
+		Action:    action,
+		Key:       key,
+		Actor:     user,
+		Timestamp: ts,
+	})
+	if err != nil {
+		return err
+	}
+
+	return historyBucket.Put(boltChecksHistoryKey(clRefString, ts), historyData)
+}
+
+// ListChecksByUser lists the checks currently checked by userID, most
+// recent first, by seeking to the end of the userID/ prefix and walking
+// backwards with Cursor.Prev() until since or limit is reached.
+func (r *boltCoreRepository) ListChecksByUser(ctx context.Context, userID int, since time.Time, limit int) ([]CheckListEntry, error) {
+	var entries []CheckListEntry
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucketNameChecksByUser))
+		prefix := []byte(fmt.Sprintf("%d/", userID))
+
+		var err error
+		entries, err = scanBoltPrefixDesc(bucket, prefix, since, limit, decodeChecksByUserKey)
+		return err
+	})
+
+	return entries, errors.Wrap(err, "ListChecksByUser")
+}
+
+// ListChecksByRepo lists the checks currently checked under owner/repo,
+// most recent first, by seeking to the end of the owner/repo/ prefix and
+// walking backwards with Cursor.Prev() until since or limit is reached.
+func (r *boltCoreRepository) ListChecksByRepo(ctx context.Context, owner, repo string, since time.Time, limit int) ([]CheckListEntry, error) {
+	var entries []CheckListEntry
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucketNameChecksByRepo))
+		prefix := []byte(fmt.Sprintf("%s/%s/", owner, repo))
+
+		var err error
+		entries, err = scanBoltPrefixDesc(bucket, prefix, since, limit, decodeChecksByRepoKey)
+		return err
+	})
+
+	return entries, errors.Wrap(err, "ListChecksByRepo")
+}
+
+// scanBoltPrefixDesc walks every key in bucket matching prefix from most
+// recent to oldest (relying on the %020d-nanosecond encoding sorting
+// lexicographically), decoding each with decode and collecting it, and
+// stopping once a decoded entry's timestamp is older than since or once
+// limit entries have been collected. An entry exactly at or after since is
+// included; one strictly before it is not, and ends the scan.
+func scanBoltPrefixDesc(bucket *bolt.Bucket, prefix []byte, since time.Time, limit int, decode func(k, v []byte) (CheckListEntry, error)) ([]CheckListEntry, error) {
+	var entries []CheckListEntry
+
+	cursor := bucket.Cursor()
+
+	for k, v := seekLastWithPrefix(cursor, prefix); k != nil && hasBoltKeyPrefix(k, prefix); k, v = cursor.Prev() {
+		if len(entries) >= limit {
+			break
+		}
+
+		entry, err := decode(k, v)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Timestamp.Before(since) {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// seekLastWithPrefix returns the last key/value pair in bucket whose key
+// starts with prefix, or (nil, nil) if there is none. It relies on no key
+// byte ever being 0xff, which holds for the '/'-joined ASCII keys used
+// throughout this file.
+func seekLastWithPrefix(cursor *bolt.Cursor, prefix []byte) (key, value []byte) {
+	upperBound := append(append([]byte{}, prefix...), 0xff)
+
+	k, v := cursor.Seek(upperBound)
+	if k == nil {
+		return cursor.Last()
+	}
+	return cursor.Prev()
+}
+
+// GetCheckHistory returns every add/remove event recorded for clRef, oldest
+// first, by scanning the checks_history bucket with a clRef/ prefix.
+func (r *boltCoreRepository) GetCheckHistory(ctx context.Context, clRef prchecklist.ChecklistRef) ([]CheckHistoryEntry, error) {
+	if err := clRef.Validate(); err != nil {
+		return nil, err
+	}
+
+	var history []CheckHistoryEntry
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucketNameChecksHistory))
+		cursor := bucket.Cursor()
+
+		prefix := []byte(clRef.String() + "/")
+
+		for k, v := cursor.Seek(prefix); k != nil && hasBoltKeyPrefix(k, prefix); k, v = cursor.Next() {
+			var entry CheckHistoryEntry
+			if err := r.codec.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			history = append(history, entry)
+		}
+
+		return nil
+	})
+
+	return history, errors.Wrap(err, "GetCheckHistory")
+}
+
+func hasBoltKeyPrefix(key, prefix []byte) bool {
+	if len(key) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeChecksByUserKey parses a checks_by_user key of the form
+// userID/timestamp/clRef back into a CheckListEntry.
+func decodeChecksByUserKey(key, value []byte) (CheckListEntry, error) {
+	parts := splitBoltKey(string(key), 3)
+	if len(parts) != 3 {
+		return CheckListEntry{}, fmt.Errorf("malformed checks_by_user key: %q", key)
+	}
+
+	ts, err := decodeBoltTimestamp(parts[1])
+	if err != nil {
+		return CheckListEntry{}, err
+	}
+
+	clRef, err := prchecklist.ParseChecklistRef(parts[2])
+	if err != nil {
+		return CheckListEntry{}, err
+	}
+
+	return CheckListEntry{CLRef: clRef, Key: string(value), Timestamp: ts}, nil
+}
+
+// decodeChecksByRepoKey parses a checks_by_repo key of the form
+// owner/repo/timestamp/clRef back into a CheckListEntry.
+func decodeChecksByRepoKey(key, value []byte) (CheckListEntry, error) {
+	parts := splitBoltKey(string(key), 4)
+	if len(parts) != 4 {
+		return CheckListEntry{}, fmt.Errorf("malformed checks_by_repo key: %q", key)
+	}
+
+	ts, err := decodeBoltTimestamp(parts[2])
+	if err != nil {
+		return CheckListEntry{}, err
+	}
+
+	clRef, err := prchecklist.ParseChecklistRef(parts[3])
+	if err != nil {
+		return CheckListEntry{}, err
+	}
+
+	return CheckListEntry{CLRef: clRef, Key: string(value), Timestamp: ts}, nil
+}
+
+func decodeBoltTimestamp(s string) (time.Time, error) {
+	var nanos int64
+	if _, err := fmt.Sscanf(s, "%020d", &nanos); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+// decodeBoltTimestampOrZero is decodeBoltTimestamp without the error return,
+// for call sites that already trust the stored value (checks_index_ref is
+// only ever written by putCheckIndexes itself).
+func decodeBoltTimestampOrZero(s string) time.Time {
+	ts, err := decodeBoltTimestamp(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
+func splitBoltKey(key string, n int) []string {
+	parts := make([]string, 0, n)
+	for i := 0; i < n-1; i++ {
+		idx := -1
+		for j := 0; j < len(key); j++ {
+			if key[j] == '/' {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			return parts
+		}
+		parts = append(parts, key[:idx])
+		key = key[idx+1:]
+	}
+	parts = append(parts, key)
+	return parts
+}
+
+// migrateCheckIndexes back-fills checks_by_user, checks_by_repo and
+// checks_history from the existing checks bucket. It runs once, the first
+// time a database created before these indexes existed is opened; it is a
+// no-op once the indexes are populated.
+//
+// The pre-index schema never recorded when a check was added, so every
+// back-filled entry is stamped with the migration time captured in
+// migratedAt below, plus a per-entry nanosecond offset that keeps their
+// composite keys distinct, not its real add time. Their relative order and any
+// since-based filtering of this back-filled data is therefore meaningless;
+// only checks added after migration get a trustworthy timestamp.
+func (r *boltCoreRepository) migrateCheckIndexes(tx *bolt.Tx) error {
+	metaBucket := tx.Bucket([]byte(boltBucketNameMeta))
+	if metaBucket.Get([]byte(boltMetaKeyIndexesBuilt)) != nil {
+		return nil
+	}
+
+	checksBucket := tx.Bucket([]byte(boltBucketNameChecks))
+	migratedAt := time.Now().UTC()
+	offset := 0
+
+	err := checksBucket.ForEach(func(k, data []byte) error {
+		clRef, err := prchecklist.ParseChecklistRef(string(k))
+		if err != nil {
+			return err
+		}
+
+		var checks prchecklist.Checks
+		if err := r.codec.Unmarshal(data, &checks); err != nil {
+			return err
+		}
+
+		for _, check := range checks {
+			ts := migratedAt.Add(time.Duration(offset))
+			offset++
+			if err := putCheckIndexes(tx, r.codec, boltCheckEventAdd, clRef, check.Key, check.User, ts); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return metaBucket.Put([]byte(boltMetaKeyIndexesBuilt), []byte("1"))
+}
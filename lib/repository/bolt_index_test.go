@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+func newTestBoltCore(t *testing.T) *boltCoreRepository {
+	t.Helper()
+
+	core, err := NewBoltCore("bolt:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewBoltCore: %v", err)
+	}
+
+	r, ok := core.(*boltCoreRepository)
+	if !ok {
+		t.Fatalf("NewBoltCore returned %T, want *boltCoreRepository", core)
+	}
+
+	return r
+}
+
+func TestListChecksByUser_MostRecentFirst(t *testing.T) {
+	r := newTestBoltCore(t)
+	ctx := context.Background()
+
+	user := prchecklist.GitHubUser{ID: 1}
+
+	refs := []prchecklist.ChecklistRef{
+		{Owner: "o", Repo: "r", Number: 1},
+		{Owner: "o", Repo: "r", Number: 2},
+		{Owner: "o", Repo: "r", Number: 3},
+	}
+
+	for _, clRef := range refs {
+		if err := r.AddCheck(ctx, clRef, "approved", user); err != nil {
+			t.Fatalf("AddCheck(%v): %v", clRef, err)
+		}
+	}
+
+	entries, err := r.ListChecksByUser(ctx, user.ID, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("ListChecksByUser: %v", err)
+	}
+
+	if len(entries) != len(refs) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(refs))
+	}
+
+	for i, want := range []int{3, 2, 1} {
+		if entries[i].CLRef.Number != want {
+			t.Errorf("entries[%d].CLRef.Number = %d, want %d (most recent first)", i, entries[i].CLRef.Number, want)
+		}
+	}
+}
+
+func TestListChecksByUser_SinceExcludesOlderEntries(t *testing.T) {
+	r := newTestBoltCore(t)
+	ctx := context.Background()
+
+	user := prchecklist.GitHubUser{ID: 1}
+
+	old := prchecklist.ChecklistRef{Owner: "o", Repo: "r", Number: 1}
+	if err := r.AddCheck(ctx, old, "approved", user); err != nil {
+		t.Fatalf("AddCheck(%v): %v", old, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	since := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+
+	recent := []prchecklist.ChecklistRef{
+		{Owner: "o", Repo: "r", Number: 2},
+		{Owner: "o", Repo: "r", Number: 3},
+	}
+	for _, clRef := range recent {
+		if err := r.AddCheck(ctx, clRef, "approved", user); err != nil {
+			t.Fatalf("AddCheck(%v): %v", clRef, err)
+		}
+	}
+
+	entries, err := r.ListChecksByUser(ctx, user.ID, since, 10)
+	if err != nil {
+		t.Fatalf("ListChecksByUser: %v", err)
+	}
+
+	if len(entries) != len(recent) {
+		t.Fatalf("got %d entries, want %d (the check before `since` must be excluded)", len(entries), len(recent))
+	}
+	for i, want := range []int{3, 2} {
+		if entries[i].CLRef.Number != want {
+			t.Errorf("entries[%d].CLRef.Number = %d, want %d (most recent first)", i, entries[i].CLRef.Number, want)
+		}
+	}
+}
+
+func TestRemoveCheck_DoesNotLeaveStaleIndexEntry(t *testing.T) {
+	r := newTestBoltCore(t)
+	ctx := context.Background()
+
+	user := prchecklist.GitHubUser{ID: 1}
+	clRef := prchecklist.ChecklistRef{Owner: "o", Repo: "r", Number: 1}
+
+	if err := r.AddCheck(ctx, clRef, "approved", user); err != nil {
+		t.Fatalf("AddCheck: %v", err)
+	}
+	if err := r.RemoveCheck(ctx, clRef, "approved", user); err != nil {
+		t.Fatalf("RemoveCheck: %v", err)
+	}
+
+	byUser, err := r.ListChecksByUser(ctx, user.ID, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("ListChecksByUser: %v", err)
+	}
+	if len(byUser) != 0 {
+		t.Errorf("ListChecksByUser after remove = %v, want empty", byUser)
+	}
+
+	byRepo, err := r.ListChecksByRepo(ctx, clRef.Owner, clRef.Repo, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("ListChecksByRepo: %v", err)
+	}
+	if len(byRepo) != 0 {
+		t.Errorf("ListChecksByRepo after remove = %v, want empty", byRepo)
+	}
+
+	history, err := r.GetCheckHistory(ctx, clRef)
+	if err != nil {
+		t.Fatalf("GetCheckHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetCheckHistory = %v, want 2 entries (add, remove)", history)
+	}
+	if history[0].Action != boltCheckEventAdd || history[1].Action != boltCheckEventRemove {
+		t.Errorf("GetCheckHistory actions = [%s, %s], want [add, remove]", history[0].Action, history[1].Action)
+	}
+}
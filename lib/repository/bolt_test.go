@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewBoltCore_RejectsNonPositiveBackupInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	for _, interval := range []string{"0s", "-1h"} {
+		_, err := NewBoltCore("bolt:" + path + "?backup_dir=" + t.TempDir() + "&backup_interval=" + interval)
+		if err == nil {
+			t.Errorf("NewBoltCore with backup_interval=%s: got nil error, want a config error", interval)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec encodes and decodes the values stored in a boltCoreRepository.
+// Implementations must be safe to reuse across goroutines.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	Name() string
+}
+
+// jsonCodec is the default Codec, kept for backward compatibility with
+// databases created before Codec existed.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// msgpackCodec stores values as MessagePack, which is smaller and faster to
+// (de)serialize than JSON for large check histories.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+// codecs maps the `codec` DSN query parameter to its Codec.
+var codecs = map[string]Codec{
+	"":        jsonCodec{},
+	"json":    jsonCodec{},
+	"msgpack": msgpackCodec{},
+}
+
+func codecByName(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %q", name)
+	}
+	return codec, nil
+}
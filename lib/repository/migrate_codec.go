@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+// MigrateCodec re-encodes every value in the "users", "checks" and
+// "checks_history" buckets of a bolt database from one Codec to another,
+// inside a single db.Update transaction. It is the function a `prchecklist
+// migrate-codec` subcommand would call, invoked before a database created
+// with one codec is opened with another (see NewBoltCore). This package has
+// no main/cmd tree of its own to wire that subcommand into, so exposing
+// this flag-parsing-and-dispatch is left to whichever entry point the
+// binary eventually grows; this chunk ships only the repository-level
+// operation the CLI would wrap.
+//
+// checks_by_user and checks_by_repo store their values as plain bytes, not
+// through a Codec, so they need no migration.
+func MigrateCodec(path string, fromCodec, toCodec string) error {
+	from, err := codecByName(fromCodec)
+	if err != nil {
+		return errors.Wrap(err, "from")
+	}
+
+	to, err := codecByName(toCodec)
+	if err != nil {
+		return errors.Wrap(err, "to")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		migrations := []struct {
+			bucketName string
+			newValue   func() interface{}
+		}{
+			{boltBucketNameUsers, func() interface{} { return new(prchecklist.GitHubUser) }},
+			{boltBucketNameChecks, func() interface{} { return new(prchecklist.Checks) }},
+			{boltBucketNameChecksHistory, func() interface{} { return new(CheckHistoryEntry) }},
+		}
+
+		for _, m := range migrations {
+			bucket := tx.Bucket([]byte(m.bucketName))
+			if bucket == nil {
+				continue
+			}
+
+			if err := migrateBucketCodec(bucket, from, to, m.newValue); err != nil {
+				return errors.Wrapf(err, "migrating bucket %q", m.bucketName)
+			}
+		}
+
+		metaBucket, err := tx.CreateBucketIfNotExists([]byte(boltBucketNameMeta))
+		if err != nil {
+			return err
+		}
+
+		return metaBucket.Put([]byte(boltMetaKeyCodec), []byte(to.Name()))
+	})
+}
+
+// migrateBucketCodec re-encodes every value in bucket from one codec to
+// another. newValue must return a fresh pointer to the concrete type stored
+// in bucket, since msgpack and JSON don't share a common decode-to-any
+// representation (struct field names, tags and numeric types diverge).
+func migrateBucketCodec(bucket *bolt.Bucket, from, to Codec, newValue func() interface{}) error {
+	type kv struct {
+		key, value []byte
+	}
+
+	var rewritten []kv
+
+	err := bucket.ForEach(func(key, data []byte) error {
+		value := newValue()
+		if err := from.Unmarshal(data, value); err != nil {
+			return err
+		}
+
+		buf, err := to.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		rewritten = append(rewritten, kv{key: append([]byte(nil), key...), value: buf})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rewritten {
+		if err := bucket.Put(r.key, r.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
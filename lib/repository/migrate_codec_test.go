@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+func TestMigrateCodec_JSONToMsgpack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+
+	core, err := NewBoltCore("bolt:" + path)
+	if err != nil {
+		t.Fatalf("NewBoltCore: %v", err)
+	}
+	r := core.(*boltCoreRepository)
+
+	user := prchecklist.GitHubUser{ID: 1, Login: "octocat"}
+	clRef := prchecklist.ChecklistRef{Owner: "o", Repo: "r", Number: 1}
+
+	if err := r.AddUser(ctx, user); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if err := r.AddCheck(ctx, clRef, "approved", user); err != nil {
+		t.Fatalf("AddCheck: %v", err)
+	}
+	if err := r.db.Close(); err != nil {
+		t.Fatalf("closing db before migration: %v", err)
+	}
+
+	if err := MigrateCodec(path, "json", "msgpack"); err != nil {
+		t.Fatalf("MigrateCodec: %v", err)
+	}
+
+	core, err = NewBoltCore("bolt:" + path + "?codec=msgpack")
+	if err != nil {
+		t.Fatalf("NewBoltCore after migration: %v", err)
+	}
+	r = core.(*boltCoreRepository)
+
+	users, err := r.GetUsers(ctx, []int{user.ID})
+	if err != nil {
+		t.Fatalf("GetUsers after migration: %v", err)
+	}
+	if got := users[user.ID]; got != user {
+		t.Errorf("GetUsers after migration = %+v, want %+v", got, user)
+	}
+
+	checks, err := r.GetChecks(ctx, clRef)
+	if err != nil {
+		t.Fatalf("GetChecks after migration: %v", err)
+	}
+	if len(checks) != 1 || checks[0].Key != "approved" || checks[0].User != user {
+		t.Errorf("GetChecks after migration = %+v, want one check by %+v", checks, user)
+	}
+
+	history, err := r.GetCheckHistory(ctx, clRef)
+	if err != nil {
+		t.Fatalf("GetCheckHistory after migration: %v", err)
+	}
+	if len(history) != 1 || history[0].Actor != user {
+		t.Errorf("GetCheckHistory after migration = %+v, want one add by %+v", history, user)
+	}
+}
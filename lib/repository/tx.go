@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+// TxRepository mirrors coreRepository's user and check operations, but every
+// operation runs against a single, already-open transaction. Callers use it
+// through WithTx to compose several operations atomically, e.g. a GraphQL
+// mutation that adds a user and a check together, or a bulk import.
+//
+// WithTx and TxRepository are only defined here on *boltCoreRepository, not
+// on the coreRepository interface: the interface's own definition isn't part
+// of this source tree (registerCoreRepositoryBuilder and every caller in
+// this chunk resolve it, but no coreRepository.go ships here), and there is
+// no Redis- or in-memory-backed coreRepository implementation alongside
+// boltCoreRepository to give a second TxRepository a convention to follow.
+// Adding WithTx to the interface and inventing MULTI/EXEC and mutex-based
+// implementations from scratch, with nothing in this tree to model them on,
+// risks shipping backends nobody asked for and nobody can review against an
+// existing pattern. Promoting WithTx onto coreRepository, and adding the
+// other backends' TxRepository implementations, is left for whoever owns
+// those backend files.
+type TxRepository interface {
+	AddUser(ctx context.Context, user prchecklist.GitHubUser) error
+	GetUsers(ctx context.Context, userIDs []int) (map[int]prchecklist.GitHubUser, error)
+	GetChecks(ctx context.Context, clRef prchecklist.ChecklistRef) (prchecklist.Checks, error)
+	AddCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error
+	RemoveCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error
+}
+
+// boltTxRepository implements TxRepository against a single *bolt.Tx. The
+// boltCoreRepository methods are themselves thin wrappers that open a
+// transaction and delegate to this type, so there is exactly one
+// implementation of the actual bucket logic.
+type boltTxRepository struct {
+	tx    *bolt.Tx
+	codec Codec
+}
+
+// WithTx opens a single writable
+// transaction and runs fn against it, so every call fn makes through
+// TxRepository commits or rolls back together.
+func (r *boltCoreRepository) WithTx(ctx context.Context, fn func(TxRepository) error) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTxRepository{tx: tx, codec: r.codec})
+	})
+}
+
+func (r *boltTxRepository) AddUser(ctx context.Context, user prchecklist.GitHubUser) error {
+	usersBucket := r.tx.Bucket([]byte(boltBucketNameUsers))
+
+	buf, err := r.codec.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return usersBucket.Put([]byte(strconv.FormatInt(int64(user.ID), 10)), buf)
+}
+
+func (r *boltTxRepository) GetUsers(ctx context.Context, userIDs []int) (map[int]prchecklist.GitHubUser, error) {
+	usersBucket := r.tx.Bucket([]byte(boltBucketNameUsers))
+
+	users := make(map[int]prchecklist.GitHubUser, len(userIDs))
+	for _, id := range userIDs {
+		buf := usersBucket.Get([]byte(strconv.FormatInt(int64(id), 10)))
+		if buf == nil {
+			return nil, fmt.Errorf("not found: user id=%v", id)
+		}
+
+		var user prchecklist.GitHubUser
+		if err := r.codec.Unmarshal(buf, &user); err != nil {
+			return nil, err
+		}
+		users[id] = user
+	}
+
+	return users, nil
+}
+
+func (r *boltTxRepository) GetChecks(ctx context.Context, clRef prchecklist.ChecklistRef) (prchecklist.Checks, error) {
+	if err := clRef.Validate(); err != nil {
+		return nil, err
+	}
+
+	checksBucket := r.tx.Bucket([]byte(boltBucketNameChecks))
+
+	var checks prchecklist.Checks
+	data := checksBucket.Get([]byte(clRef.String()))
+	if data != nil {
+		if err := r.codec.Unmarshal(data, &checks); err != nil {
+			return nil, err
+		}
+	}
+
+	return checks, nil
+}
+
+func (r *boltTxRepository) AddCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
+	if err := clRef.Validate(); err != nil {
+		return err
+	}
+
+	checksBucket := r.tx.Bucket([]byte(boltBucketNameChecks))
+
+	var checks prchecklist.Checks
+	dbKey := []byte(clRef.String())
+	data := checksBucket.Get(dbKey)
+	if data != nil {
+		if err := r.codec.Unmarshal(data, &checks); err != nil {
+			return err
+		}
+	}
+
+	if checks == nil {
+		checks = prchecklist.Checks{}
+	}
+
+	if checks.Add(key, user) == false {
+		return nil
+	}
+
+	data, err := r.codec.Marshal(&checks)
+	if err != nil {
+		return err
+	}
+
+	if err := checksBucket.Put(dbKey, data); err != nil {
+		return err
+	}
+
+	return putCheckIndexes(r.tx, r.codec, boltCheckEventAdd, clRef, key, user, time.Now().UTC())
+}
+
+func (r *boltTxRepository) RemoveCheck(ctx context.Context, clRef prchecklist.ChecklistRef, key string, user prchecklist.GitHubUser) error {
+	if err := clRef.Validate(); err != nil {
+		return err
+	}
+
+	checksBucket := r.tx.Bucket([]byte(boltBucketNameChecks))
+
+	var checks prchecklist.Checks
+	dbKey := []byte(clRef.String())
+	data := checksBucket.Get(dbKey)
+	if data != nil {
+		if err := r.codec.Unmarshal(data, &checks); err != nil {
+			return err
+		}
+	}
+
+	if checks == nil {
+		checks = prchecklist.Checks{}
+	}
+
+	if checks.Remove(key, user) == false {
+		return nil
+	}
+
+	data, err := r.codec.Marshal(&checks)
+	if err != nil {
+		return err
+	}
+
+	if err := checksBucket.Put(dbKey, data); err != nil {
+		return err
+	}
+
+	return putCheckIndexes(r.tx, r.codec, boltCheckEventRemove, clRef, key, user, time.Now().UTC())
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/motemen/prchecklist/v2"
+)
+
+func TestWithTx_ComposesAddUserAndAddCheck(t *testing.T) {
+	r := newTestBoltCore(t)
+	ctx := context.Background()
+
+	user := prchecklist.GitHubUser{ID: 1, Login: "octocat"}
+	clRef := prchecklist.ChecklistRef{Owner: "o", Repo: "r", Number: 1}
+
+	err := r.WithTx(ctx, func(tx TxRepository) error {
+		if err := tx.AddUser(ctx, user); err != nil {
+			return err
+		}
+		return tx.AddCheck(ctx, clRef, "approved", user)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	users, err := r.GetUsers(ctx, []int{user.ID})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if users[user.ID] != user {
+		t.Errorf("GetUsers = %+v, want %+v", users[user.ID], user)
+	}
+
+	checks, err := r.GetChecks(ctx, clRef)
+	if err != nil {
+		t.Fatalf("GetChecks: %v", err)
+	}
+	if len(checks) != 1 || checks[0].Key != "approved" {
+		t.Errorf("GetChecks = %+v, want one check \"approved\"", checks)
+	}
+}